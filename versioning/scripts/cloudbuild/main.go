@@ -13,6 +13,7 @@ import (
 	"text/template"
 
 	"github.com/GoogleCloudPlatform/runtimes-common/versioning/versions"
+	"gopkg.in/yaml.v2"
 )
 
 type cloudBuildOptions struct {
@@ -40,11 +41,68 @@ type cloudBuildOptions struct {
 
 	// Forces parallel build. If specified, images are build on bigger machines in parallel. Overrides EnableParallel.
 	ForceParallel bool
+
+	// Default list of target platforms for multi-arch buildx builds, e.g. ["linux/amd64", "linux/arm64"].
+	// Applies to every version unless overridden by that version's own Platforms field in versions.yaml.
+	Platforms []string
+
+	// Build backend used for plain Dockerfile builds, one of "docker" or "kaniko".
+	// Does not affect versions that set BuilderImage, which use their declared builder image under either backend.
+	// A version with platforms (see Platforms) is built with docker buildx instead, whatever the backend and even
+	// if it sets BuilderImage.
+	Builder string
+
+	// Number of shards to split each functional test across. Each shard runs as its own step,
+	// passing --shard_index/--shard_total to gcr.io/$PROJECT_ID/functional_test. A value of 1
+	// (the default) emits a single step per test with no shard flags, matching legacy functional_test
+	// binaries that don't recognize them.
+	TestShards int
+
+	// Optional per-step timeout applied to each build and structure test step, independent of the
+	// overall build's TimeoutSeconds. Overridden per version by versions.yaml's BuildTimeout.
+	StepTimeoutSeconds int
+
+	// Optional per-step timeout applied to each functional test step. Overridden per version by
+	// versions.yaml's TestTimeout.
+	TestTimeoutSeconds int
+
+	// Number of times to attempt a functional test step before failing the build.
+	// A value of 1 or less (the default) runs the test once with no retry wrapper.
+	MaxRetries int
 }
 
+const dockerBuilder = "docker"
+const kanikoBuilder = "kaniko"
+
+const formatCloudBuild = "cloudbuild"
+const formatBake = "bake"
+
 // TODO(huyhg): Replace "gcr.io/$PROJECT_ID/functional_test" with gcp-runtimes one.
 const cloudBuildTemplateString = `steps:
 {{- $parallel := .Parallel }}
+{{- if .UsesBuildx }}
+
+# Set up docker buildx for multi-arch builds
+  - name: gcr.io/cloud-builders/docker
+    args:
+      - 'run'
+      - '--privileged'
+      - 'tonistiigi/binfmt'
+      - '--install'
+      - 'all'
+{{- if $parallel }}
+    waitFor: ['-']
+{{- end }}
+  - name: gcr.io/cloud-builders/docker
+    args:
+      - 'buildx'
+      - 'create'
+      - '--use'
+{{- if $parallel }}
+    waitFor: ['-']
+{{- end }}
+    id: 'buildx-setup'
+{{- end }}
 {{- if .RequireNewTags }}
 # Check if tags exist.
 {{- range .Images }}
@@ -58,12 +116,50 @@ const cloudBuildTemplateString = `steps:
 
 # Build images
 {{- range .ImageBuilds }}
+{{- if .Platforms }}
+  - name: gcr.io/cloud-builders/docker
+    args:
+      - 'buildx'
+      - 'build'
+      - '--platform={{ join .Platforms "," }}'
+      - '--push'
+      - '--tag={{ .Tag }}'
+      - '{{ .Directory }}'
+{{- if .StepTimeoutSeconds }}
+    timeout: {{ .StepTimeoutSeconds }}s
+{{- end }}
+{{- if $parallel }}
+    waitFor: ['buildx-setup']
+    id: 'image-{{ .Tag }}'
+{{- end }}
+{{- else if .Kaniko }}
+  - name: gcr.io/kaniko-project/executor
+    args:
+      - '--dockerfile={{ .Directory }}/Dockerfile'
+      - '--destination={{ .Tag }}'
+{{- range .Aliases }}
+      - '--destination={{ . }}'
+{{- end }}
+      - '--context=dir://{{ .Directory }}'
+      - '--cache=true'
+      - '--cache-repo={{ .CacheRepo }}'
+{{- if .StepTimeoutSeconds }}
+    timeout: {{ .StepTimeoutSeconds }}s
+{{- end }}
+{{- if $parallel }}
+    waitFor: ['-']
+    id: 'image-{{ .Tag }}'
+{{- end }}
+{{- else }}
 {{- if .Builder }}
   - name: gcr.io/cloud-builders/docker
     args:
       - 'build'
       - '--tag={{ .Tag }}'
       - '{{ .Directory }}'
+{{- if .StepTimeoutSeconds }}
+    timeout: {{ .StepTimeoutSeconds }}s
+{{- end }}
 {{- if $parallel }}
     waitFor: ['-']
     id: 'image-{{ .Tag }}'
@@ -72,6 +168,9 @@ const cloudBuildTemplateString = `steps:
 {{- if .BuilderImage }}
   - name: {{ .BuilderImage }}
     args: {{ .BuilderArgs }}
+{{- if .StepTimeoutSeconds }}
+    timeout: {{ .StepTimeoutSeconds }}s
+{{- end }}
 {{- if $parallel }}
     waitFor: ['image-{{ .BuilderImage }}']
     id: 'image-{{ .Tag }}'
@@ -82,6 +181,9 @@ const cloudBuildTemplateString = `steps:
       - 'build'
       - '--tag={{ .Tag }}'
       - '{{ .Directory }}'
+{{- if .StepTimeoutSeconds }}
+    timeout: {{ .StepTimeoutSeconds }}s
+{{- end }}
 {{- if $parallel }}
     waitFor: ['-']
     id: 'image-{{ .Tag }}'
@@ -89,6 +191,7 @@ const cloudBuildTemplateString = `steps:
 {{- end }}
 {{- end }}
 {{- end }}
+{{- end }}
 
 {{- range $imageIndex, $image := .ImageBuilds }}
 {{- $primary := $image.Tag }}
@@ -103,6 +206,9 @@ const cloudBuildTemplateString = `steps:
       - '{{ $primary }}'
       - '--config'
       - '{{ $test }}'
+{{- if $image.StepTimeoutSeconds }}
+    timeout: {{ $image.StepTimeoutSeconds }}s
+{{- end }}
 {{- end }}
 {{- end }}
 
@@ -113,18 +219,38 @@ const cloudBuildTemplateString = `steps:
 
 # Run functional tests
 {{- end }}
+{{- if gt $image.MaxRetries 1 }}
+  - name: gcr.io/cloud-builders/docker
+    entrypoint: bash
+    args:
+      - '-c'
+      - |
+        for attempt in $(seq 1 {{ $image.MaxRetries }}); do
+          docker run --rm gcr.io/$PROJECT_ID/functional_test --verbose --vars "IMAGE={{ $primary }}" --vars "UNIQUE={{ $imageIndex }}-{{ $testIndex }}-{{ $test.ShardIndex }}" --test_spec "{{ $test.Test }}"{{ if gt $test.ShardTotal 1 }} --shard_index={{ $test.ShardIndex }} --shard_total={{ $test.ShardTotal }}{{ end }} && exit 0
+          echo "functional_test attempt $attempt failed, retrying..."
+        done
+        exit 1
+{{- else }}
   - name: gcr.io/$PROJECT_ID/functional_test
     args:
       - '--verbose'
       - '--vars'
       - 'IMAGE={{ $primary }}'
       - '--vars'
-      - 'UNIQUE={{ $imageIndex }}-{{ $testIndex }}'
+      - 'UNIQUE={{ $imageIndex }}-{{ $testIndex }}-{{ $test.ShardIndex }}'
       - '--test_spec'
-      - '{{ $test }}'
+      - '{{ $test.Test }}'
+{{- if gt $test.ShardTotal 1 }}
+      - '--shard_index={{ $test.ShardIndex }}'
+      - '--shard_total={{ $test.ShardTotal }}'
+{{- end }}
+{{- end }}
+{{- if $image.TestTimeoutSeconds }}
+    timeout: {{ $image.TestTimeoutSeconds }}s
+{{- end }}
 {{- if $parallel }}
     waitFor: ['image-{{ $primary }}']
-    id: 'test-{{ $primary }}-{{ $testIndex }}'
+    id: 'test-{{ $primary }}-{{ $testIndex }}-{{ $test.ShardIndex }}'
 {{- end }}
 {{- end }}
 
@@ -133,17 +259,29 @@ const cloudBuildTemplateString = `steps:
 # Add alias tags
 {{- range $imageIndex, $image := .ImageBuilds }}
 {{- $primary := $image.Tag }}
+{{- if not $image.Kaniko }}
 {{- range .Aliases }}
+{{- if $image.Platforms }}
+  - name: gcr.io/cloud-builders/docker
+    args:
+      - 'buildx'
+      - 'imagetools'
+      - 'create'
+      - '--tag={{ . }}'
+      - '{{ $primary }}'
+{{- else }}
   - name: gcr.io/cloud-builders/docker
     args:
       - 'tag'
       - '{{ $primary }}'
       - '{{ . }}'
+{{- end }}
 {{- if $parallel }}
     waitFor:
       - 'image-{{ $primary }}'
 {{- range $testIndex, $test := $image.FunctionalTests }}
-      - 'test-{{ $primary }}-{{ $testIndex }}'
+      - 'test-{{ $primary }}-{{ $testIndex }}-{{ $test.ShardIndex }}'
+{{- end }}
 {{- end }}
 {{- end }}
 {{- end }}
@@ -170,6 +308,44 @@ options:
 {{- end }}
 `
 
+// bakeTemplateString renders a docker-bake.hcl describing the same build matrix as
+// cloudBuildTemplateString, so contributors can reproduce CI's build with `docker buildx bake`.
+const bakeTemplateString = `{{- range .Targets }}
+target "{{ .Name }}" {
+  context    = "{{ .Context }}"
+  dockerfile = "{{ .Dockerfile }}"
+  tags = [
+{{- range .Tags }}
+    "{{ . }}",
+{{- end }}
+  ]
+{{- if .Platforms }}
+  platforms = [
+{{- range .Platforms }}
+    "{{ . }}",
+{{- end }}
+  ]
+{{- end }}
+{{- if .Args }}
+  args = {
+{{- range $key, $value := .Args }}
+    {{ $key }} = "{{ $value }}"
+{{- end }}
+  }
+{{- end }}
+}
+
+{{- end }}
+
+group "default" {
+  targets = [
+{{- range .Targets }}
+    "{{ .Name }}",
+{{- end }}
+  ]
+}
+`
+
 const testsDir = "tests"
 const functionalTestsDir = "tests/functional_tests"
 const structureTestsDir = "tests/structure_tests"
@@ -177,16 +353,38 @@ const testJsonSuffix = "_test.json"
 const testYamlSuffix = "_test.yaml"
 const workspacePrefix = "/workspace/"
 
+// functionalTestShard describes a single shard of a functional test's run, i.e. one
+// gcr.io/$PROJECT_ID/functional_test step invocation.
+type functionalTestShard struct {
+	Test       string
+	ShardIndex int
+	ShardTotal int
+}
+
+// functionalTestFile is a functional test file together with its optional per-test shard
+// count override, read from a top-level "shards" key in the file itself. Shards of 0 means
+// no override; the global --test_shards value applies.
+type functionalTestFile struct {
+	Path   string
+	Shards int
+}
+
 type imageBuildTemplateData struct {
 	Directory            string
 	Tag                  string
 	Aliases              []string
 	StructureTests       []string
-	FunctionalTests      []string
+	FunctionalTests      []functionalTestShard
 	Builder              bool
 	BuilderImage         string
 	BuilderArgs          []string
 	ImageNameFromBuilder string
+	Platforms            []string
+	Kaniko               bool
+	CacheRepo            string
+	StepTimeoutSeconds   int
+	TestTimeoutSeconds   int
+	MaxRetries           int
 }
 
 type cloudBuildTemplateData struct {
@@ -196,6 +394,20 @@ type cloudBuildTemplateData struct {
 	AllImages      []string
 	TimeoutSeconds int
 	MachineType    string
+	UsesBuildx     bool
+}
+
+type bakeTargetData struct {
+	Name       string
+	Context    string
+	Dockerfile string
+	Tags       []string
+	Platforms  []string
+	Args       map[string]string
+}
+
+type bakeFileData struct {
+	Targets []bakeTargetData
 }
 
 func shouldParallelize(options cloudBuildOptions, numberOfVersions int, numberOfTests int) bool {
@@ -213,26 +425,16 @@ func newCloudBuildTemplateData(
 	data := cloudBuildTemplateData{}
 	data.RequireNewTags = options.RequireNewTags
 
-	// Determine the set of directories to operate on.
-	dirs := make(map[string]bool)
-	if len(options.Directories) > 0 {
-		for _, d := range options.Directories {
-			dirs[d] = true
-		}
-	} else {
-		for _, v := range spec.Versions {
-			dirs[v.Dir] = true
-		}
-	}
+	dirs := resolveDirectories(spec, options)
 
 	// Extract tests to run.
 	var structureTests []string
-	var functionalTests []string
+	var functionalTests []functionalTestFile
 	if options.RunTests {
 		// Legacy structure tests reside in the root tests/ directory.
 		structureTests = append(structureTests, readTests(testsDir)...)
 		structureTests = append(structureTests, readTests(structureTestsDir)...)
-		functionalTests = append(functionalTests, readTests(functionalTestsDir)...)
+		functionalTests = append(functionalTests, readFunctionalTests(functionalTestsDir)...)
 	}
 
 	// Extract a list of full image names to build.
@@ -240,36 +442,129 @@ func newCloudBuildTemplateData(
 		if !dirs[v.Dir] {
 			continue
 		}
-		var images []string
-		for _, t := range v.Tags {
-			image := fmt.Sprintf("%v/%v:%v", registry, v.Repo, t)
-			images = append(images, image)
-			if options.FirstTagOnly {
-				break
-			}
-		}
+		images := buildImageList(registry, v, options.FirstTagOnly)
 		// Ignore builder images from images list
 		if !v.Builder {
 			data.AllImages = append(data.AllImages, images...)
 		}
 		versionSTests, versionFTests := filterTests(structureTests, functionalTests, v)
+		versionFTestShards := shardFunctionalTests(versionFTests, options.TestShards)
+		platforms := options.Platforms
+		if len(v.Platforms) > 0 {
+			platforms = v.Platforms
+		}
+		cacheRepo := fmt.Sprintf("%v/cache", registry)
+		stepTimeout := options.StepTimeoutSeconds
+		if v.BuildTimeout != 0 {
+			stepTimeout = v.BuildTimeout
+		}
+		testTimeout := options.TestTimeoutSeconds
+		if v.TestTimeout != 0 {
+			testTimeout = v.TestTimeout
+		}
 		// Enforce to use ImageNameFromBuilder as reference to create tags
 		if v.BuilderImage != "" {
 			BuilderImageFull := fmt.Sprintf("%v/%v", registry, v.BuilderImage)
 			data.ImageBuilds = append(
-				data.ImageBuilds, imageBuildTemplateData{v.Dir, v.ImageNameFromBuilder, images, versionSTests, versionFTests, v.Builder, BuilderImageFull, v.BuilderArgs, v.ImageNameFromBuilder})
+				data.ImageBuilds, imageBuildTemplateData{v.Dir, v.ImageNameFromBuilder, images, versionSTests, versionFTestShards, v.Builder, BuilderImageFull, v.BuilderArgs, v.ImageNameFromBuilder, platforms, false, cacheRepo, stepTimeout, testTimeout, options.MaxRetries})
 		} else {
+			kaniko := options.Builder == kanikoBuilder
 			data.ImageBuilds = append(
-				data.ImageBuilds, imageBuildTemplateData{v.Dir, images[0], images[1:], versionSTests, versionFTests, v.Builder, v.BuilderImage, v.BuilderArgs, v.ImageNameFromBuilder})
+				data.ImageBuilds, imageBuildTemplateData{v.Dir, images[0], images[1:], versionSTests, versionFTestShards, v.Builder, v.BuilderImage, v.BuilderArgs, v.ImageNameFromBuilder, platforms, kaniko, cacheRepo, stepTimeout, testTimeout, options.MaxRetries})
+		}
+		if len(platforms) > 0 {
+			data.UsesBuildx = true
 		}
 	}
 
 	data.TimeoutSeconds = options.TimeoutSeconds
 	data.MachineType = options.MachineType
-	data.Parallel = shouldParallelize(options, len(spec.Versions), len(functionalTests))
+	data.Parallel = shouldParallelize(options, len(spec.Versions), len(functionalTests)*options.TestShards)
 	return data
 }
 
+// resolveDirectories determines the set of Dockerfile directories to operate on, honoring
+// options.Directories when set and otherwise defaulting to every version in spec.
+func resolveDirectories(spec versions.Spec, options cloudBuildOptions) map[string]bool {
+	dirs := make(map[string]bool)
+	if len(options.Directories) > 0 {
+		for _, d := range options.Directories {
+			dirs[d] = true
+		}
+	} else {
+		for _, v := range spec.Versions {
+			dirs[v.Dir] = true
+		}
+	}
+	return dirs
+}
+
+// buildImageList returns the full image names (registry/repo:tag) declared for a version,
+// honoring firstTagOnly.
+func buildImageList(registry string, v versions.Version, firstTagOnly bool) []string {
+	var images []string
+	for _, t := range v.Tags {
+		image := fmt.Sprintf("%v/%v:%v", registry, v.Repo, t)
+		images = append(images, image)
+		if firstTagOnly {
+			break
+		}
+	}
+	return images
+}
+
+// newBakeFileData builds the docker-bake target list for spec, sharing the Directories and
+// FirstTagOnly filtering of newCloudBuildTemplateData.
+func newBakeFileData(registry string, spec versions.Spec, options cloudBuildOptions) bakeFileData {
+	data := bakeFileData{}
+	dirs := resolveDirectories(spec, options)
+
+	for _, v := range spec.Versions {
+		if !dirs[v.Dir] {
+			continue
+		}
+		images := buildImageList(registry, v, options.FirstTagOnly)
+		platforms := options.Platforms
+		if len(v.Platforms) > 0 {
+			platforms = v.Platforms
+		}
+		data.Targets = append(data.Targets, bakeTargetData{
+			Name:       bakeTargetName(v.Dir),
+			Context:    v.Dir,
+			Dockerfile: v.Dir + "/Dockerfile",
+			Tags:       images,
+			Platforms:  platforms,
+			Args:       bakeBuildArgs(v.BuilderArgs),
+		})
+	}
+	return data
+}
+
+// bakeBuildArgs picks the "KEY=VALUE" entries out of a version's BuilderArgs (a flat CLI
+// argument list, e.g. ["--flag", "KEY=VALUE"]) and returns them as a build-arg map suitable for
+// docker-bake.hcl's args block. Entries without an "=" aren't build args and are skipped.
+func bakeBuildArgs(builderArgs []string) map[string]string {
+	args := map[string]string{}
+	for _, arg := range builderArgs {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		args[parts[0]] = parts[1]
+	}
+	return args
+}
+
+// bakeTargetName turns a Dockerfile directory into a valid HCL target identifier.
+func bakeTargetName(dir string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '.' {
+			return '_'
+		}
+		return r
+	}, dir)
+}
+
 func readTests(testsDir string) (tests []string) {
 	if info, err := os.Stat(testsDir); err == nil && info.IsDir() {
 		files, err := ioutil.ReadDir(testsDir)
@@ -286,11 +581,41 @@ func readTests(testsDir string) (tests []string) {
 	return
 }
 
-func filterTests(structureTests []string, functionalTests []string, version versions.Version) (outStructureTests []string, outFunctionalTests []string) {
+// readFunctionalTests is readTests plus, for each file, a per-test --test_shards override read
+// from a top-level "shards" key in the file itself.
+func readFunctionalTests(testsDir string) (tests []functionalTestFile) {
+	for _, path := range readTests(testsDir) {
+		localPath := strings.TrimPrefix(path, workspacePrefix)
+		tests = append(tests, functionalTestFile{path, readTestShardOverride(localPath)})
+	}
+	return
+}
+
+// readTestShardOverride returns the "shards" value declared in the test file at path, or 0 if
+// the file has none, can't be read, or can't be parsed. YAML parses the JSON test files too, so
+// this works for both _test.json and _test.yaml.
+func readTestShardOverride(path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	var override struct {
+		Shards int `yaml:"shards"`
+	}
+	if err := yaml.Unmarshal(data, &override); err != nil {
+		return 0
+	}
+	return override.Shards
+}
+
+func filterTests(structureTests []string, functionalTests []functionalTestFile, version versions.Version) (outStructureTests []string, outFunctionalTests []functionalTestFile) {
 	included := make(map[string]bool, len(structureTests)+len(functionalTests))
-	for _, test := range append(structureTests, functionalTests...) {
+	for _, test := range structureTests {
 		included[test] = true
 	}
+	for _, test := range functionalTests {
+		included[test.Path] = true
+	}
 	for _, excluded := range version.ExcludeTests {
 		if !included[workspacePrefix+excluded] {
 			log.Fatalf("No such test to exclude: %s", excluded)
@@ -304,26 +629,60 @@ func filterTests(structureTests []string, functionalTests []string, version vers
 			outStructureTests = append(outStructureTests, test)
 		}
 	}
-	outFunctionalTests = make([]string, 0, len(functionalTests))
+	outFunctionalTests = make([]functionalTestFile, 0, len(functionalTests))
 	for _, test := range functionalTests {
-		if included[test] {
+		if included[test.Path] {
 			outFunctionalTests = append(outFunctionalTests, test)
 		}
 	}
 	return
 }
 
+// shardFunctionalTests expands each functional test into step entries, one per --shard_index.
+// A test's own Shards override is used when set; otherwise defaultShardCount applies. A shard
+// count of 1 or less emits a single unsharded entry, so legacy functional_test binaries that
+// don't recognize --shard_index/--shard_total still work.
+func shardFunctionalTests(tests []functionalTestFile, defaultShardCount int) []functionalTestShard {
+	shards := make([]functionalTestShard, 0, len(tests))
+	for _, test := range tests {
+		shardCount := defaultShardCount
+		if test.Shards > 0 {
+			shardCount = test.Shards
+		}
+		if shardCount < 1 {
+			shardCount = 1
+		}
+		for i := 0; i < shardCount; i++ {
+			shards = append(shards, functionalTestShard{test.Path, i, shardCount})
+		}
+	}
+	return shards
+}
+
 func renderCloudBuildConfig(
 	registry string, spec versions.Spec, options cloudBuildOptions) string {
 	data := newCloudBuildTemplateData(registry, spec, options)
 	tmpl, _ := template.
 		New("cloudBuildTemplate").
+		Funcs(template.FuncMap{
+			"join": strings.Join,
+		}).
 		Parse(cloudBuildTemplateString)
 	var result bytes.Buffer
 	tmpl.Execute(&result, data)
 	return result.String()
 }
 
+func renderBakeFile(registry string, spec versions.Spec, options cloudBuildOptions) string {
+	data := newBakeFileData(registry, spec, options)
+	tmpl, _ := template.
+		New("bakeTemplate").
+		Parse(bakeTemplateString)
+	var result bytes.Buffer
+	tmpl.Execute(&result, data)
+	return result.String()
+}
+
 func check(e error) {
 	if e != nil {
 		panic(e)
@@ -341,12 +700,31 @@ func main() {
 	machineTypePtr := config.StringOption("machineType","", "Optional machine type used to run the build, , must be one of: N1_HIGHCPU_8, N1_HIGHCPU_32, E2_HIGHCPU_8, E2_HIGHCPU_32. If not specified, the default machine is used.")
 	enableParallel := config.BoolOption("enable_parallel", false, "Enable parallel build and bigger VM")
 	forceParallel := config.BoolOption("force_parallel", false, "Force parallel build and bigger VM")
+	platformsPtr := config.StringOption("platforms", "", "Comma separated list of target platforms for multi-arch buildx builds, e.g. 'linux/amd64,linux/arm64'. Overridden per version by versions.yaml's Platforms field.")
+	builderPtr := config.StringOption("builder", dockerBuilder, "Build backend to use for plain Dockerfile builds, one of 'docker' or 'kaniko'.")
+	testShardsPtr := config.IntOption("test_shards", 1, "Number of shards to split each functional test across.")
+	formatPtr := config.StringOption("format", formatCloudBuild, "Output format, one of 'cloudbuild' (a Cloud Build yaml) or 'bake' (a docker-bake.hcl).")
+	stepTimeoutPtr := config.IntOption("step_timeout", 0, "Per-step timeout in seconds for build and structure test steps. If not set, no per-step timeout is applied.")
+	testTimeoutPtr := config.IntOption("test_timeout", 0, "Per-step timeout in seconds for functional test steps. If not set, no per-step timeout is applied.")
+	maxRetriesPtr := config.IntOption("max_retries", 1, "Number of times to attempt a functional test step before failing the build. 1 (the default) disables retries.")
 	config.Parse()
 
 	if *registryPtr == "" {
 		log.Fatalf("--registry flag is required")
 	}
 
+	if *builderPtr != dockerBuilder && *builderPtr != kanikoBuilder {
+		log.Fatalf("--builder must be one of %q or %q", dockerBuilder, kanikoBuilder)
+	}
+
+	if *testShardsPtr < 1 {
+		log.Fatalf("--test_shards must be at least 1")
+	}
+
+	if *formatPtr != formatCloudBuild && *formatPtr != formatBake {
+		log.Fatalf("--format must be one of %q or %q", formatCloudBuild, formatBake)
+	}
+
 	if strings.Contains(*registryPtr, ":") {
 		*registryPtr = strings.Replace(*registryPtr, ":", "/", 1)
 	}
@@ -355,8 +733,18 @@ func main() {
 	if *dirsPtr != "" {
 		dirs = strings.Split(*dirsPtr, ",")
 	}
+	var platforms []string
+	if *platformsPtr != "" {
+		platforms = strings.Split(*platformsPtr, ",")
+	}
 	spec := versions.LoadVersions("versions.yaml")
-	options := cloudBuildOptions{dirs, *testsPtr, *newTagsPtr, *firstTagOnly, *timeoutPtr, *machineTypePtr, *enableParallel, *forceParallel}
-	result := renderCloudBuildConfig(*registryPtr, spec, options)
+	options := cloudBuildOptions{dirs, *testsPtr, *newTagsPtr, *firstTagOnly, *timeoutPtr, *machineTypePtr, *enableParallel, *forceParallel, platforms, *builderPtr, *testShardsPtr, *stepTimeoutPtr, *testTimeoutPtr, *maxRetriesPtr}
+
+	var result string
+	if *formatPtr == formatBake {
+		result = renderBakeFile(*registryPtr, spec, options)
+	} else {
+		result = renderCloudBuildConfig(*registryPtr, spec, options)
+	}
 	fmt.Println(result)
 }
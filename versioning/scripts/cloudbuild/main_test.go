@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/runtimes-common/versioning/versions"
+)
+
+func TestNewBakeFileData(t *testing.T) {
+	spec := versions.Spec{
+		Versions: []versions.Version{
+			{
+				Dir:         "go/1.16",
+				Repo:        "go",
+				Tags:        []string{"1.16", "latest"},
+				Platforms:   []string{"linux/amd64", "linux/arm64"},
+				BuilderArgs: []string{"--build-arg", "GO_VERSION=1.16"},
+			},
+		},
+	}
+	options := cloudBuildOptions{}
+
+	data := newBakeFileData("gcr.io/my-project", spec, options)
+
+	if len(data.Targets) != 1 {
+		t.Fatalf("got %d targets, want 1", len(data.Targets))
+	}
+	target := data.Targets[0]
+	if target.Name != "go_1_16" {
+		t.Errorf("Name = %q, want %q", target.Name, "go_1_16")
+	}
+	if target.Context != "go/1.16" {
+		t.Errorf("Context = %q, want %q", target.Context, "go/1.16")
+	}
+	if target.Dockerfile != "go/1.16/Dockerfile" {
+		t.Errorf("Dockerfile = %q, want %q", target.Dockerfile, "go/1.16/Dockerfile")
+	}
+	wantTags := []string{"gcr.io/my-project/go:1.16", "gcr.io/my-project/go:latest"}
+	if len(target.Tags) != len(wantTags) || target.Tags[0] != wantTags[0] || target.Tags[1] != wantTags[1] {
+		t.Errorf("Tags = %v, want %v", target.Tags, wantTags)
+	}
+	if target.Args["GO_VERSION"] != "1.16" {
+		t.Errorf("Args[GO_VERSION] = %q, want %q", target.Args["GO_VERSION"], "1.16")
+	}
+	if _, ok := target.Args["--build-arg"]; ok {
+		t.Errorf("Args should not contain the bare --build-arg flag")
+	}
+}
+
+func TestRenderBakeFile(t *testing.T) {
+	spec := versions.Spec{
+		Versions: []versions.Version{
+			{
+				Dir:         "go/1.16",
+				Repo:        "go",
+				Tags:        []string{"1.16"},
+				BuilderArgs: []string{"GO_VERSION=1.16"},
+			},
+		},
+	}
+
+	result := renderBakeFile("gcr.io/my-project", spec, cloudBuildOptions{})
+
+	wantSnippets := []string{
+		`target "go_1_16" {`,
+		`context    = "go/1.16"`,
+		`dockerfile = "go/1.16/Dockerfile"`,
+		`"gcr.io/my-project/go:1.16"`,
+		`GO_VERSION = "1.16"`,
+		`group "default" {`,
+	}
+	for _, snippet := range wantSnippets {
+		if !strings.Contains(result, snippet) {
+			t.Errorf("renderBakeFile() output missing %q, got:\n%s", snippet, result)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Package versions loads the versions.yaml manifest shared by the versioning scripts (image
+tags, Dockerfile directories, excluded tests, ...) and the small flag/config helper those
+scripts use to expose CLI options that can also be defaulted from versions.yaml.
+*/
+package versions
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Version describes one Dockerfile directory's build: the tags it publishes, the tests that
+// cover it, and how it should be built.
+type Version struct {
+	// Dir is the Dockerfile directory, relative to the repo root.
+	Dir string `yaml:"dir"`
+
+	// Repo is the image repository name, combined with the registry and each tag to form the
+	// full image reference.
+	Repo string `yaml:"repo"`
+
+	// Tags are the tags to publish for this version. The first tag is the primary tag; the rest
+	// are aliases.
+	Tags []string `yaml:"tags"`
+
+	// ExcludeTests lists test files (relative to the repo root, without the /workspace/ prefix)
+	// that should not run against this version.
+	ExcludeTests []string `yaml:"excludeTests"`
+
+	// Builder marks this version as producing an intermediate builder image for other versions
+	// to reference via BuilderImage, rather than a published runtime image.
+	Builder bool `yaml:"builder"`
+
+	// BuilderImage, when set, names a builder image to invoke instead of `docker build`, e.g. a
+	// prior Builder version's image. BuilderArgs are passed to it as-is.
+	BuilderImage string `yaml:"builderImage"`
+
+	// BuilderArgs are the CLI arguments passed to BuilderImage.
+	BuilderArgs []string `yaml:"builderArgs"`
+
+	// ImageNameFromBuilder is the image name BuilderImage produces, used as the tag reference
+	// when BuilderImage is set.
+	ImageNameFromBuilder string `yaml:"imageNameFromBuilder"`
+
+	// Platforms overrides the cloudbuild generator's --platforms flag for this version, e.g.
+	// ["linux/amd64", "linux/arm64"]. Leave empty to use the global --platforms default.
+	Platforms []string `yaml:"platforms"`
+
+	// BuildTimeout overrides the cloudbuild generator's --step_timeout flag, in seconds, for this
+	// version's build and structure test steps. Zero means use the global --step_timeout default.
+	BuildTimeout int `yaml:"buildTimeout"`
+
+	// TestTimeout overrides the cloudbuild generator's --test_timeout flag, in seconds, for this
+	// version's functional test steps. Zero means use the global --test_timeout default.
+	TestTimeout int `yaml:"testTimeout"`
+}
+
+// Spec is the parsed contents of versions.yaml.
+type Spec struct {
+	Versions []Version `yaml:"versions"`
+}
+
+// LoadVersions reads and parses the versions.yaml manifest at path.
+func LoadVersions(path string) Spec {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", path, err)
+	}
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		log.Fatalf("Unable to parse %s: %v", path, err)
+	}
+	return spec
+}
+
+// Config loads CLI flags, defaulting each one from the named tool's section of versions.yaml
+// (configs.<tool>.<flag>) when versions.yaml declares one, before falling back to the option's
+// own default. A value passed on the command line always wins.
+type Config struct {
+	defaults map[string]interface{}
+}
+
+type configFile struct {
+	Configs map[string]map[string]interface{} `yaml:"configs"`
+}
+
+// LoadConfig reads the configs.<tool> section of versions.yaml, if any, for use as flag
+// defaults. A missing versions.yaml or configs section simply yields no overrides.
+func LoadConfig(versionsPath string, tool string) *Config {
+	config := &Config{defaults: map[string]interface{}{}}
+	data, err := ioutil.ReadFile(versionsPath)
+	if err != nil {
+		return config
+	}
+	var file configFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		log.Fatalf("Unable to parse %s: %v", versionsPath, err)
+	}
+	if overrides, ok := file.Configs[tool]; ok {
+		config.defaults = overrides
+	}
+	return config
+}
+
+// StringOption registers a string flag named name, defaulted to def unless versions.yaml
+// overrides it.
+func (c *Config) StringOption(name string, def string, usage string) *string {
+	if v, ok := c.defaults[name]; ok {
+		if s, ok := v.(string); ok {
+			def = s
+		}
+	}
+	return flag.String(name, def, usage)
+}
+
+// BoolOption registers a bool flag named name, defaulted to def unless versions.yaml overrides
+// it.
+func (c *Config) BoolOption(name string, def bool, usage string) *bool {
+	if v, ok := c.defaults[name]; ok {
+		if b, ok := v.(bool); ok {
+			def = b
+		}
+	}
+	return flag.Bool(name, def, usage)
+}
+
+// IntOption registers an int flag named name, defaulted to def unless versions.yaml overrides
+// it.
+func (c *Config) IntOption(name string, def int, usage string) *int {
+	if v, ok := c.defaults[name]; ok {
+		if i, ok := v.(int); ok {
+			def = i
+		}
+	}
+	return flag.Int(name, def, usage)
+}
+
+// Parse parses the registered flags from os.Args.
+func (c *Config) Parse() {
+	flag.CommandLine.Parse(os.Args[1:])
+}